@@ -3,6 +3,8 @@
 package aws
 
 import (
+	"bytes"
+	"crypto/rand"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -67,6 +69,75 @@ func TestEncryptDecryptWithAWS(t *testing.T) {
 				assert.Equal(t, expected, string(actual))
 			},
 		},
+		{
+			name: "envelope encrypt decrypt multi-MB file",
+			f: func(tc TestCase) {
+				amazon := NewEnvelope(key, region)
+				crypt := crypto.New(amazon)
+
+				inputFile := "test-large.txt"
+				expected := make([]byte, 8*1024*1024) // well over KMS's ~4 KiB Encrypt limit
+				_, err := rand.Read(expected)
+				if err != nil {
+					t.Fatal("Can't generate plaintext", err)
+				}
+				err = ioutil.WriteFile(inputFile, expected, 0644)
+				if err != nil {
+					t.Fatal("Can't write plaintext file", err)
+				}
+				defer os.Remove(inputFile)
+
+				actual, err := when(crypt, inputFile)
+
+				assert.NoError(t, err)
+				assert.Equal(t, string(expected), actual)
+			},
+		},
+		{
+			name: "envelope decrypt detects tampered ciphertext",
+			f: func(tc TestCase) {
+				amazon := NewEnvelope(key, region)
+				crypt := crypto.New(amazon)
+
+				inputFile := "test-tamper.txt"
+				err := ioutil.WriteFile(inputFile, []byte("top secret token"), 0644)
+				if err != nil {
+					t.Fatal("Can't write plaintext file", err)
+				}
+				defer os.Remove(inputFile)
+				defer os.Remove(inputFile + ".encrypted")
+				defer os.Remove(inputFile + ".decrypted")
+
+				err = crypt.EncryptFile(inputFile, inputFile+".encrypted")
+				if err != nil {
+					t.Fatal("Can't encrypt file", err)
+				}
+
+				encrypted, err := ioutil.ReadFile(inputFile + ".encrypted")
+				if err != nil {
+					t.Fatal("Can't read encrypted file", err)
+				}
+				tampered := bytes.Repeat([]byte{0}, len(encrypted))
+				copy(tampered, encrypted)
+				tampered[len(tampered)-1] ^= 0xFF // flip a bit in the GCM tag
+				err = ioutil.WriteFile(inputFile+".encrypted", tampered, 0644)
+				if err != nil {
+					t.Fatal("Can't write tampered file", err)
+				}
+
+				err = crypt.DecryptFile(inputFile+".encrypted", inputFile+".decrypted")
+				assert.Error(t, err)
+
+				truncated := encrypted[:len(encrypted)-10]
+				err = ioutil.WriteFile(inputFile+".encrypted", truncated, 0644)
+				if err != nil {
+					t.Fatal("Can't write truncated file", err)
+				}
+
+				err = crypt.DecryptFile(inputFile+".encrypted", inputFile+".decrypted")
+				assert.Error(t, err)
+			},
+		},
 	}
 
 	logrus.SetLevel(logrus.DebugLevel)
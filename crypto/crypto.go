@@ -0,0 +1,503 @@
+// Package crypto provides a KMS-agnostic file encryption API. A Crypt wraps
+// a backend-specific Encrypter (aws.New, vault.New, ...) and handles the
+// on-disk container format, so callers never deal with a particular KMS's
+// API or size limits directly.
+package crypto
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+
+	"github.com/VirtusLab/crypt/iofs"
+)
+
+// Encrypter is implemented by the backend-specific KMS clients (aws.New,
+// vault.New, ...). Encrypt/Decrypt operate on whole blobs, so a backend
+// that encrypts directly (no envelope) is subject to its KMS's plaintext
+// size limit.
+type Encrypter interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+}
+
+// EnvelopeEncrypter is implemented by backends that can generate a local
+// data-encryption key (DEK) and wrap/unwrap it under a KMS master key.
+// When an Encrypter also implements EnvelopeEncrypter, Crypt encrypts the
+// body locally with the DEK and only ever sends the (small) DEK to the
+// KMS, lifting the plaintext size limits of a plain Encrypter.
+type EnvelopeEncrypter interface {
+	Encrypter
+
+	// GenerateDataKey returns a new random DEK, its ciphertext wrapped
+	// under the backend's configured master key, and the identifier of
+	// that master key (recorded in the container for reference only;
+	// Decrypt skips it and leaves the unwrapping to UnwrapDataKey).
+	GenerateDataKey() (plaintextKey, wrappedKey []byte, keyID string, err error)
+
+	// UnwrapDataKey asks the KMS to decrypt a DEK previously wrapped by
+	// GenerateDataKey.
+	UnwrapDataKey(wrappedKey []byte) ([]byte, error)
+}
+
+const (
+	envelopeMagic = "CRPT"
+
+	// envelopeVersionBuffered is the original envelope container: a
+	// single AES-256-GCM seal of the whole plaintext. It requires
+	// buffering the body in memory and is kept only so Decrypt can still
+	// read files produced by older versions of this package.
+	envelopeVersionBuffered = byte(1)
+
+	// envelopeVersionStream splits the body into fixed-size frames, each
+	// sealed independently, so Encrypt/Decrypt can stream arbitrarily
+	// large bodies in constant memory. This is the version Encrypt
+	// writes today.
+	envelopeVersionStream = byte(2)
+
+	nonceSize        = 12 // envelopeVersionBuffered nonce
+	noncePrefixSize  = 8  // envelopeVersionStream per-stream random nonce prefix
+	frameCounterSize = 4  // envelopeVersionStream per-frame big-endian counter
+	frameSize        = 64 * 1024
+)
+
+// Format selects the on-disk container Encrypt writes. Decrypt never
+// consults it: it always sniffs the format from the input's magic header,
+// so a Crypt can decrypt files written in any format it supports
+// regardless of how it's configured to encrypt.
+type Format int
+
+const (
+	// FormatEnvelope is the default container (see EnvelopeEncrypter).
+	FormatEnvelope Format = iota
+
+	// FormatPGP wraps the body in a standard OpenPGP SEIP packet, so ops
+	// teams can decrypt it with familiar tooling once they have the
+	// unwrapped session key (see pgp.go).
+	FormatPGP
+)
+
+// Option configures a Crypt constructed with New.
+type Option func(*Crypt)
+
+// WithFormat sets the container format Encrypt writes.
+func WithFormat(format Format) Option {
+	return func(c *Crypt) { c.format = format }
+}
+
+// WithArmor ASCII-armors the OpenPGP body when format is FormatPGP. It has
+// no effect otherwise.
+func WithArmor(armor bool) Option {
+	return func(c *Crypt) { c.armor = armor }
+}
+
+// Crypt encrypts and decrypts using the configured Encrypter.
+type Crypt struct {
+	Encrypter
+
+	format Format
+	armor  bool
+}
+
+// New returns a Crypt backed by the given Encrypter.
+func New(encrypter Encrypter, opts ...Option) *Crypt {
+	c := &Crypt{Encrypter: encrypter}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Encrypt streams r into w, encrypted in the container selected by
+// WithFormat (FormatEnvelope by default). FormatPGP requires the
+// underlying Encrypter to also implement EnvelopeEncrypter; FormatEnvelope
+// uses it when available and otherwise falls back to a direct, whole-body
+// KMS Encrypt, subject to the KMS's plaintext size limit.
+func (c *Crypt) Encrypt(r io.Reader, w io.Writer) error {
+	if c.format == FormatPGP {
+		ee, ok := c.Encrypter.(EnvelopeEncrypter)
+		if !ok {
+			return errors.New("crypto: pgp format requires an envelope-capable backend")
+		}
+		return encryptPGP(ee, r, w, c.armor)
+	}
+
+	if ee, ok := c.Encrypter.(EnvelopeEncrypter); ok {
+		return streamEncryptEnvelope(ee, r, w)
+	}
+
+	plaintext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := c.Encrypter.Encrypt(plaintext)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(ciphertext)
+	return err
+}
+
+// Decrypt streams r into w, decrypted. It sniffs r's magic header to tell
+// apart the envelope container (both the legacy buffered version and the
+// current framed one), the PGP container, and a raw KMS-encrypted blob
+// produced by older versions of this package, and dispatches accordingly.
+func (c *Crypt) Decrypt(r io.Reader, w io.Writer) error {
+	br := bufio.NewReaderSize(r, frameSize)
+
+	peek, err := br.Peek(len(envelopeMagic))
+	switch {
+	case err == nil && string(peek) == envelopeMagic:
+		ee, ok := c.Encrypter.(EnvelopeEncrypter)
+		if !ok {
+			return errors.New("crypto: input is envelope-encrypted but backend does not support envelope decryption")
+		}
+		return streamDecryptEnvelope(ee, br, w)
+
+	case err == nil && string(peek) == pgpMagic:
+		ee, ok := c.Encrypter.(EnvelopeEncrypter)
+		if !ok {
+			return errors.New("crypto: input is pgp-encrypted but backend does not support envelope decryption")
+		}
+		return decryptPGP(ee, br, w)
+	}
+
+	ciphertext, err := ioutil.ReadAll(br)
+	if err != nil {
+		return err
+	}
+	plaintext, err := c.Encrypter.Decrypt(ciphertext)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(plaintext)
+	return err
+}
+
+// EncryptFile reads inputPath, encrypts it and writes the result to
+// outputPath. It is an alias of EncryptURL: inputPath/outputPath may be
+// plain local paths or URIs such as "s3://bucket/key" or "gs://bucket/obj".
+func (c *Crypt) EncryptFile(inputPath, outputPath string) error {
+	return c.EncryptURL(inputPath, outputPath)
+}
+
+// DecryptFile reads inputPath, decrypts it and writes the plaintext to
+// outputPath. It is an alias of DecryptURL: inputPath/outputPath may be
+// plain local paths or URIs such as "s3://bucket/key" or "gs://bucket/obj".
+func (c *Crypt) DecryptFile(inputPath, outputPath string) error {
+	return c.DecryptURL(inputPath, outputPath)
+}
+
+// EncryptURL streams inputURL into outputURL, encrypted (see Encrypt).
+// Both may be plain local paths or URIs handled by a backend registered
+// with iofs.Register (e.g. "s3://bucket/key", "gs://bucket/obj").
+func (c *Crypt) EncryptURL(inputURL, outputURL string) error {
+	in, err := iofs.Open(inputURL)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := iofs.Create(outputURL)
+	if err != nil {
+		return err
+	}
+	if err := c.Encrypt(in, out); err != nil {
+		abortAndDelete(out, outputURL)
+		return err
+	}
+	return out.Close()
+}
+
+// DecryptURL streams inputURL into outputURL, decrypted (see Decrypt).
+// Both may be plain local paths or URIs handled by a backend registered
+// with iofs.Register.
+func (c *Crypt) DecryptURL(inputURL, outputURL string) error {
+	in, err := iofs.Open(inputURL)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := iofs.Create(outputURL)
+	if err != nil {
+		return err
+	}
+	if err := c.Decrypt(in, out); err != nil {
+		abortAndDelete(out, outputURL)
+		return err
+	}
+	return out.Close()
+}
+
+// abortAndDelete unwinds a partially written output after a failed
+// Encrypt/Decrypt, so callers never leave a truncated or garbage object
+// at outputURL. It aborts the in-flight write when the backend supports
+// it (see iofs.Aborter), otherwise just closes it, and either way follows
+// up with an explicit delete as a backstop. Both are best-effort: if
+// outputURL was never created there's nothing to clean up, and there's no
+// better error to surface than the one the caller is already returning.
+func abortAndDelete(out io.WriteCloser, outputURL string) {
+	if a, ok := out.(iofs.Aborter); ok {
+		a.Abort()
+	} else {
+		out.Close()
+	}
+	iofs.Delete(outputURL)
+}
+
+// streamEncryptEnvelope generates a DEK and writes the framed envelope
+// container:
+//
+//	magic(4) | version(1) | len(keyID)(2) | keyID | len(wrappedDEK)(2) | wrappedDEK | noncePrefix(8) | frame...
+//
+// Each frame is `isFinal(1) | len(ciphertext+tag)(4) | ciphertext+tag`,
+// sealed with AES-256-GCM under a nonce of noncePrefix||frameCounter and
+// AAD of frameCounter||isFinal, so a frame can't be reordered or replayed
+// at another position, and the stream can't be silently truncated: the
+// reader only accepts it as complete once it has decrypted a frame marked
+// final (see frameAAD).
+func streamEncryptEnvelope(ee EnvelopeEncrypter, r io.Reader, w io.Writer) error {
+	dek, wrappedDEK, keyID, err := ee.GenerateDataKey()
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return err
+	}
+
+	noncePrefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(noncePrefix); err != nil {
+		return err
+	}
+
+	if err := writeEnvelopeHeader(w, envelopeVersionStream, keyID, wrappedDEK); err != nil {
+		return err
+	}
+	if _, err := w.Write(noncePrefix); err != nil {
+		return err
+	}
+
+	buf := make([]byte, frameSize)
+	for counter := uint32(0); ; counter++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.EOF && readErr != io.ErrUnexpectedEOF {
+			return readErr
+		}
+		final := readErr == io.EOF || readErr == io.ErrUnexpectedEOF || n < frameSize
+		if err := writeFrame(w, gcm, noncePrefix, counter, buf[:n], final); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// streamDecryptEnvelope parses the container written by
+// streamEncryptEnvelope (or, for envelopeVersionBuffered, the original
+// single-frame container) and writes the decrypted body to w.
+func streamDecryptEnvelope(ee EnvelopeEncrypter, r io.Reader, w io.Writer) error {
+	version, wrappedDEK, err := readEnvelopeHeader(r)
+	if err != nil {
+		return err
+	}
+
+	dek, err := ee.UnwrapDataKey(wrappedDEK)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(dek)
+	if err != nil {
+		return err
+	}
+
+	switch version {
+	case envelopeVersionBuffered:
+		return decryptBufferedBody(gcm, r, w)
+	case envelopeVersionStream:
+		return decryptStreamBody(gcm, r, w)
+	default:
+		return errors.New("crypto: unsupported envelope version")
+	}
+}
+
+func decryptBufferedBody(gcm cipher.AEAD, r io.Reader, w io.Writer) error {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return errors.New("crypto: truncated envelope container")
+	}
+	ciphertext, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return errors.New("crypto: ciphertext failed authentication")
+	}
+	_, err = w.Write(plaintext)
+	return err
+}
+
+func decryptStreamBody(gcm cipher.AEAD, r io.Reader, w io.Writer) error {
+	noncePrefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(r, noncePrefix); err != nil {
+		return errors.New("crypto: truncated envelope container")
+	}
+
+	for counter := uint32(0); ; counter++ {
+		ciphertext, final, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+
+		plaintext, err := gcm.Open(nil, frameNonce(noncePrefix, counter), ciphertext, frameAAD(counter, final))
+		if err != nil {
+			return errors.New("crypto: ciphertext failed authentication")
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return err
+		}
+
+		if final {
+			return nil
+		}
+	}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func frameNonce(noncePrefix []byte, counter uint32) []byte {
+	nonce := make([]byte, noncePrefixSize+frameCounterSize)
+	copy(nonce, noncePrefix)
+	binary.BigEndian.PutUint32(nonce[noncePrefixSize:], counter)
+	return nonce
+}
+
+// frameAAD binds a frame's ciphertext to its position in the stream and
+// to whether it is the last frame, so dropping trailing frames (a
+// truncation attack) is caught: the decrypter only accepts the stream as
+// complete once it has seen a frame whose AAD says it's final.
+func frameAAD(counter uint32, final bool) []byte {
+	aad := make([]byte, frameCounterSize+1)
+	binary.BigEndian.PutUint32(aad, counter)
+	if final {
+		aad[frameCounterSize] = 1
+	}
+	return aad
+}
+
+// writeFrame writes a frame as `final(1) | len(ciphertext+tag)(4) |
+// ciphertext+tag`. The final flag travels in the clear (it's also part of
+// the sealed AAD, so it can't be flipped without invalidating the GCM
+// tag) so the reader can tell, without decrypting anything further,
+// whether more frames are supposed to follow.
+func writeFrame(w io.Writer, gcm cipher.AEAD, noncePrefix []byte, counter uint32, plaintext []byte, final bool) error {
+	ciphertext := gcm.Seal(nil, frameNonce(noncePrefix, counter), plaintext, frameAAD(counter, final))
+
+	header := make([]byte, 1+4)
+	if final {
+		header[0] = 1
+	}
+	binary.BigEndian.PutUint32(header[1:], uint32(len(ciphertext)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(ciphertext)
+	return err
+}
+
+// maxFrameCiphertextSize bounds the frame length readFrame will trust
+// before allocating for it: a plaintext frame of at most frameSize, plus
+// room for the GCM tag. Anything larger can only be a corrupted or
+// malicious header, not a frame this package ever wrote.
+const maxFrameCiphertextSize = frameSize + 64
+
+func readFrame(r io.Reader) (ciphertext []byte, final bool, err error) {
+	header := make([]byte, 1+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, false, errors.New("crypto: truncated envelope container")
+	}
+	final = header[0] == 1
+	length := binary.BigEndian.Uint32(header[1:])
+	if length > maxFrameCiphertextSize {
+		return nil, false, errors.New("crypto: invalid envelope container: frame too large")
+	}
+	ciphertext = make([]byte, length)
+	if _, err := io.ReadFull(r, ciphertext); err != nil {
+		return nil, false, errors.New("crypto: truncated envelope container")
+	}
+	return ciphertext, final, nil
+}
+
+func writeEnvelopeHeader(w io.Writer, version byte, keyID string, wrappedDEK []byte) error {
+	if _, err := w.Write([]byte(envelopeMagic)); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{version}); err != nil {
+		return err
+	}
+	if err := writeLenPrefixed(w, []byte(keyID)); err != nil {
+		return err
+	}
+	return writeLenPrefixed(w, wrappedDEK)
+}
+
+func readEnvelopeHeader(r io.Reader) (version byte, wrappedDEK []byte, err error) {
+	magic := make([]byte, len(envelopeMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != envelopeMagic {
+		return 0, nil, errors.New("crypto: not a valid envelope container")
+	}
+
+	v := make([]byte, 1)
+	if _, err := io.ReadFull(r, v); err != nil {
+		return 0, nil, err
+	}
+
+	if _, err := readLenPrefixed(r); err != nil { // keyID is informational only; the backend already knows its key
+		return 0, nil, err
+	}
+
+	wrappedDEK, err = readLenPrefixed(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return v[0], wrappedDEK, nil
+}
+
+func writeLenPrefixed(w io.Writer, b []byte) error {
+	var length [2]byte
+	binary.BigEndian.PutUint16(length[:], uint16(len(b)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readLenPrefixed(r io.Reader) ([]byte, error) {
+	length := make([]byte, 2)
+	if _, err := io.ReadFull(r, length); err != nil {
+		return nil, errors.New("crypto: truncated envelope container")
+	}
+	b := make([]byte, binary.BigEndian.Uint16(length))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, errors.New("crypto: truncated envelope container")
+	}
+	return b, nil
+}
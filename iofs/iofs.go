@@ -0,0 +1,112 @@
+// Package iofs lets crypto.Crypt read and write ciphertext from anywhere,
+// not just the local filesystem. Callers address input/output by URI
+// (e.g. "s3://bucket/key", "gs://bucket/object", "file:///tmp/x" or a
+// plain local path) and iofs dispatches to whichever FS is registered for
+// that URI's scheme.
+package iofs
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+)
+
+// FS opens, creates or deletes the object addressed by a URI for a single
+// scheme. Implementations are registered with Register, typically from an
+// init() in a backend-specific file such as s3.go or gcs.go.
+type FS interface {
+	Open(uri string) (io.ReadCloser, error)
+	Create(uri string) (io.WriteCloser, error)
+	Delete(uri string) error
+}
+
+// Aborter is optionally implemented by the io.WriteCloser Create returns.
+// Callers that fail partway through writing should call Abort instead of
+// Close so the backend can cancel the write in-flight (e.g. S3 aborts the
+// multipart upload, GCS cancels the writer's context) instead of
+// finalizing a truncated object.
+type Aborter interface {
+	Abort() error
+}
+
+var registry = map[string]FS{
+	"":     fileFS{}, // plain local paths have no scheme
+	"file": fileFS{},
+}
+
+// Register installs fs as the handler for the given URI scheme.
+func Register(scheme string, fs FS) {
+	registry[scheme] = fs
+}
+
+// Open resolves uri's scheme to a registered FS and opens it for reading.
+func Open(uri string) (io.ReadCloser, error) {
+	fs, err := lookup(uri)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(uri)
+}
+
+// Create resolves uri's scheme to a registered FS and opens it for
+// writing, creating or truncating the target. On error the caller is
+// responsible for cleaning up any partially written target.
+func Create(uri string) (io.WriteCloser, error) {
+	fs, err := lookup(uri)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Create(uri)
+}
+
+// Delete resolves uri's scheme to a registered FS and removes the target.
+// It's used to clean up after a write that failed partway through, as a
+// backstop after Abort (or Close, for writers without Aborter).
+func Delete(uri string) error {
+	fs, err := lookup(uri)
+	if err != nil {
+		return err
+	}
+	return fs.Delete(uri)
+}
+
+func lookup(uri string) (FS, error) {
+	scheme := schemeOf(uri)
+	fs, ok := registry[scheme]
+	if !ok {
+		return nil, fmt.Errorf("iofs: no backend registered for scheme %q", scheme)
+	}
+	return fs, nil
+}
+
+func schemeOf(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || len(u.Scheme) <= 1 { // exclude Windows drive letters such as "C:"
+		return ""
+	}
+	return u.Scheme
+}
+
+// fileFS is the default FS, serving plain local paths and file:// URIs.
+type fileFS struct{}
+
+func (fileFS) Open(uri string) (io.ReadCloser, error) {
+	return os.Open(localPath(uri))
+}
+
+func (fileFS) Create(uri string) (io.WriteCloser, error) {
+	return os.Create(localPath(uri))
+}
+
+func (fileFS) Delete(uri string) error {
+	return os.Remove(localPath(uri))
+}
+
+func localPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Host + u.Path
+}
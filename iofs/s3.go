@@ -0,0 +1,114 @@
+package iofs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func init() {
+	Register("s3", s3FS{})
+}
+
+// s3FS reads and writes objects in Amazon S3, authenticating with the
+// same default credential chain as the aws KMS backend.
+type s3FS struct{}
+
+func (s3FS) Open(uri string) (io.ReadCloser, error) {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	sess := session.Must(session.NewSession())
+	out, err := s3.New(sess).GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iofs: can't open %s: %w", uri, err)
+	}
+	return out.Body, nil
+}
+
+func (s3FS) Create(uri string) (io.WriteCloser, error) {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return nil, err
+	}
+	sess := session.Must(session.NewSession())
+	return newS3Writer(s3manager.NewUploader(sess), bucket, key), nil
+}
+
+func (s3FS) Delete(uri string) error {
+	bucket, key, err := parseS3URI(uri)
+	if err != nil {
+		return err
+	}
+	sess := session.Must(session.NewSession())
+	_, err = s3.New(sess).DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+func parseS3URI(uri string) (bucket, key string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "s3" {
+		return "", "", fmt.Errorf("iofs: invalid s3 URI %q", uri)
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// errUploadAborted is the error Abort feeds the pipe so the in-flight
+// Upload fails and unwinds (aborting its multipart upload, if any)
+// instead of completing with a truncated body.
+var errUploadAborted = errors.New("iofs: upload aborted")
+
+// s3Writer adapts s3manager's reader-based Upload to the io.WriteCloser
+// that Crypt writes ciphertext into: writes are streamed through a pipe
+// into a concurrent upload, and Close waits for that upload to finish.
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func newS3Writer(uploader *s3manager.Uploader, bucket, key string) *s3Writer {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		_, err := uploader.Upload(&s3manager.UploadInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   pr,
+		})
+		done <- err
+	}()
+	return &s3Writer{pw: pw, done: done}
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *s3Writer) Close() error {
+	if err := w.pw.Close(); err != nil {
+		return err
+	}
+	return <-w.done
+}
+
+// Abort cancels the in-flight upload instead of letting it complete with
+// whatever was written so far.
+func (w *s3Writer) Abort() error {
+	w.pw.CloseWithError(errUploadAborted)
+	<-w.done
+	return nil
+}
@@ -0,0 +1,178 @@
+// +build integration
+
+package vault
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/VirtusLab/crypt/crypto"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptDecryptWithVault(t *testing.T) {
+	type TestCase struct {
+		name    string
+		f       func(TestCase)
+		logHook *test.Hook
+	}
+
+	// configuration from config.env; VAULT_ADDR/VAULT_TOKEN are read
+	// directly by vaultapi.NewClient
+	mount := os.Getenv("VAULT_TRANSIT_MOUNT")
+	keyName := os.Getenv("VAULT_TRANSIT_KEY")
+
+	when := func(crypt *crypto.Crypt, inputPath string) (string, error) {
+		defer os.Remove(inputPath + ".encrypted") // clean up
+		defer os.Remove(inputPath + ".decrypted") // clean up
+
+		err := crypt.EncryptFile(inputPath, inputPath+".encrypted")
+		if err != nil {
+			return "", err
+		}
+
+		err = crypt.DecryptFile(inputPath+".encrypted", inputPath+".decrypted")
+		if err != nil {
+			return "", err
+		}
+
+		result, err := ioutil.ReadFile(inputPath + ".decrypted")
+		if err != nil {
+			return "", err
+		}
+
+		return string(result), nil
+	}
+
+	cases := []TestCase{
+		{
+			name: "encrypt decrypt file",
+			f: func(tc TestCase) {
+				backend, err := New(mount, keyName)
+				if err != nil {
+					t.Fatal("Can't create Vault backend", err)
+				}
+				crypt := crypto.New(backend)
+
+				inputFile := "test.txt"
+				expected := "top secret token"
+				err = ioutil.WriteFile(inputFile, []byte(expected), 0644)
+				if err != nil {
+					t.Fatal("Can't write plaintext file", err)
+				}
+				defer os.Remove(inputFile)
+
+				actual, err := when(crypt, inputFile)
+
+				assert.NoError(t, err)
+				assert.Equal(t, expected, string(actual))
+			},
+		},
+		{
+			name: "encrypt decrypt file with derived key context",
+			f: func(tc TestCase) {
+				backend, err := New(mount, keyName)
+				if err != nil {
+					t.Fatal("Can't create Vault backend", err)
+				}
+				backend = backend.WithContext([]byte("tenant-a"))
+				crypt := crypto.New(backend)
+
+				inputFile := "test-context.txt"
+				expected := "top secret token"
+				err = ioutil.WriteFile(inputFile, []byte(expected), 0644)
+				if err != nil {
+					t.Fatal("Can't write plaintext file", err)
+				}
+				defer os.Remove(inputFile)
+
+				actual, err := when(crypt, inputFile)
+
+				assert.NoError(t, err)
+				assert.Equal(t, expected, string(actual))
+			},
+		},
+		{
+			name: "decrypt still works after key rotation",
+			f: func(tc TestCase) {
+				backend, err := New(mount, keyName)
+				if err != nil {
+					t.Fatal("Can't create Vault backend", err)
+				}
+				crypt := crypto.New(backend)
+
+				inputFile := "test-rotate.txt"
+				expected := "top secret token"
+				err = ioutil.WriteFile(inputFile, []byte(expected), 0644)
+				if err != nil {
+					t.Fatal("Can't write plaintext file", err)
+				}
+				defer os.Remove(inputFile)
+				defer os.Remove(inputFile + ".encrypted")
+				defer os.Remove(inputFile + ".decrypted")
+
+				err = crypt.EncryptFile(inputFile, inputFile+".encrypted")
+				if err != nil {
+					t.Fatal("Can't encrypt file", err)
+				}
+
+				// rotate the Transit key so the ciphertext we just
+				// produced now carries an older "vault:v1:..." prefix
+				_, err = backend.client.Logical().Write(fmt.Sprintf("%s/keys/%s/rotate", mount, keyName), nil)
+				if err != nil {
+					t.Fatal("Can't rotate Transit key", err)
+				}
+
+				err = crypt.DecryptFile(inputFile+".encrypted", inputFile+".decrypted")
+				if err != nil {
+					t.Fatal("Can't decrypt file after rotation", err)
+				}
+
+				actual, err := ioutil.ReadFile(inputFile + ".decrypted")
+				assert.NoError(t, err)
+				assert.Equal(t, expected, string(actual))
+			},
+		},
+		{
+			name: "envelope encrypt decrypt multi-MB file",
+			f: func(tc TestCase) {
+				backend, err := NewEnvelope(mount, keyName)
+				if err != nil {
+					t.Fatal("Can't create Vault backend", err)
+				}
+				crypt := crypto.New(backend)
+
+				inputFile := "test-large.txt"
+				expected := make([]byte, 8*1024*1024)
+				_, err = rand.Read(expected)
+				if err != nil {
+					t.Fatal("Can't generate plaintext", err)
+				}
+				err = ioutil.WriteFile(inputFile, expected, 0644)
+				if err != nil {
+					t.Fatal("Can't write plaintext file", err)
+				}
+				defer os.Remove(inputFile)
+
+				actual, err := when(crypt, inputFile)
+
+				assert.NoError(t, err)
+				assert.Equal(t, string(expected), actual)
+			},
+		},
+	}
+
+	logrus.SetLevel(logrus.DebugLevel)
+	hook := test.NewGlobal()
+
+	for i, c := range cases {
+		c.logHook = hook
+		t.Run(fmt.Sprintf("[%d] %s", i, c.name), func(t *testing.T) { c.f(c) })
+		hook.Reset()
+	}
+}